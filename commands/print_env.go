@@ -0,0 +1,297 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/bosh-bootloader/flags"
+	"github.com/cloudfoundry/bosh-bootloader/storage"
+	"github.com/cloudfoundry/bosh-bootloader/terraform"
+)
+
+type logger interface {
+	Println(string)
+}
+
+type stateValidator interface {
+	Validate() error
+}
+
+type sshKeyGetter interface {
+	Get(deployment string) (string, error)
+}
+
+type credhubGetter interface {
+	GetServer() (string, error)
+	GetCerts() (string, error)
+	GetPassword() (string, error)
+}
+
+type terraformManager interface {
+	GetOutputs(storage.State) (terraform.Outputs, error)
+}
+
+type fileIO interface {
+	TempDir(dir, prefix string) (string, error)
+	WriteFile(filename string, contents []byte, perm os.FileMode) error
+	Rename(oldpath, newpath string) error
+}
+
+type PrintEnv struct {
+	logger           logger
+	stderrLogger     logger
+	stateValidator   stateValidator
+	sshKeyGetter     sshKeyGetter
+	credhubGetter    credhubGetter
+	terraformManager terraformManager
+	fileIO           fileIO
+}
+
+func NewPrintEnv(logger logger, stderrLogger logger, stateValidator stateValidator, sshKeyGetter sshKeyGetter, credhubGetter credhubGetter, terraformManager terraformManager, fileIO fileIO) PrintEnv {
+	return PrintEnv{
+		logger:           logger,
+		stderrLogger:     stderrLogger,
+		stateValidator:   stateValidator,
+		sshKeyGetter:     sshKeyGetter,
+		credhubGetter:    credhubGetter,
+		terraformManager: terraformManager,
+		fileIO:           fileIO,
+	}
+}
+
+func (p PrintEnv) CheckFastFails(subcommandFlags []string, state storage.State) error {
+	return p.stateValidator.Validate()
+}
+
+// envVar is one exported variable. Quoted mirrors the historical bash output,
+// where values that might contain spaces or special characters (certs) are
+// wrapped in single quotes.
+type envVar struct {
+	Key    string
+	Value  string
+	Quoted bool
+}
+
+func (p PrintEnv) Execute(subcommandFlags []string, state storage.State) error {
+	var shell, dumpFile string
+
+	printEnvFlags := flags.New("print-env")
+	printEnvFlags.String(&shell, "shell", "bash")
+	printEnvFlags.String(&dumpFile, "dump-file", "")
+	err := printEnvFlags.Parse(subcommandFlags)
+	if err != nil {
+		return err
+	}
+
+	var envVars []envVar
+
+	if state.NoDirector {
+		terraformOutputs, err := p.terraformManager.GetOutputs(state)
+		if err != nil {
+			return err
+		}
+
+		externalIP, ok := terraformOutputs.Map["external_ip"].(string)
+		if !ok {
+			return errors.New("external_ip is not present in terraform output")
+		}
+
+		envVars = append(envVars, envVar{Key: "BOSH_ENVIRONMENT", Value: fmt.Sprintf("https://%s:25555", externalIP)})
+	} else {
+		if state.BOSH.DirectorAddress != "" {
+			envVars = append(envVars,
+				envVar{Key: "BOSH_CLIENT", Value: state.BOSH.DirectorUsername},
+				envVar{Key: "BOSH_CLIENT_SECRET", Value: state.BOSH.DirectorPassword},
+				envVar{Key: "BOSH_CA_CERT", Value: state.BOSH.DirectorSSLCA, Quoted: true},
+				envVar{Key: "BOSH_ENVIRONMENT", Value: state.BOSH.DirectorAddress},
+			)
+
+			server, err := p.credhubGetter.GetServer()
+			if err != nil {
+				p.stderrLogger.Println("No credhub server found.")
+			} else {
+				envVars = append(envVars, envVar{Key: "CREDHUB_SERVER", Value: server})
+			}
+
+			certs, err := p.credhubGetter.GetCerts()
+			if err != nil {
+				p.stderrLogger.Println("No credhub certs found.")
+			} else {
+				envVars = append(envVars, envVar{Key: "CREDHUB_CA_CERT", Value: certs, Quoted: true})
+			}
+
+			password, err := p.credhubGetter.GetPassword()
+			if err != nil {
+				p.stderrLogger.Println("No credhub password found.")
+			} else {
+				envVars = append(envVars,
+					envVar{Key: "CREDHUB_USER", Value: "credhub-cli"},
+					envVar{Key: "CREDHUB_PASSWORD", Value: password},
+				)
+			}
+		}
+
+		privateKey, err := p.sshKeyGetter.Get("jumpbox")
+		if err != nil {
+			return err
+		}
+
+		// When dumping to a file, the key needs to live as long as the dump
+		// file does, so it's written alongside it instead of to a temp dir
+		// that may be cleaned up before the dump is ever sourced.
+		keyDir := filepath.Dir(dumpFile)
+		if dumpFile == "" {
+			keyDir, err = p.fileIO.TempDir("", "")
+			if err != nil {
+				return err
+			}
+		}
+
+		jumpboxPrivateKeyPath := filepath.Join(keyDir, "bosh_jumpbox_private.key")
+		err = p.fileIO.WriteFile(jumpboxPrivateKeyPath, []byte(privateKey), os.FileMode(0600))
+		if err != nil {
+			return err
+		}
+
+		privateKeyRef := jumpboxPrivateKeyPath
+		if shell != "json" {
+			privateKeyRef = varRef(shell, "JUMPBOX_PRIVATE_KEY")
+		}
+
+		envVars = append(envVars,
+			envVar{Key: "JUMPBOX_PRIVATE_KEY", Value: jumpboxPrivateKeyPath},
+			envVar{Key: "BOSH_ALL_PROXY", Value: fmt.Sprintf("ssh+socks5://jumpbox@%s?private-key=%s", state.Jumpbox.URL, privateKeyRef)},
+		)
+	}
+
+	rendered, err := renderEnvVars(shell, envVars)
+	if err != nil {
+		return err
+	}
+
+	if dumpFile != "" {
+		return p.dumpEnv(dumpFile, rendered)
+	}
+
+	for _, line := range rendered {
+		p.logger.Println(line)
+	}
+
+	return nil
+}
+
+// dumpEnv writes rendered to path instead of stdout, so that CI pipelines can
+// source the result without parsing bbl's log output. The write is staged in
+// a sibling temp file and renamed into place so that readers of path never
+// observe a partial write, and the file is created with owner-only
+// permissions since it carries credentials.
+func (p PrintEnv) dumpEnv(path string, rendered []string) error {
+	contents := []byte(strings.Join(rendered, "\n") + "\n")
+
+	tempFile := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.tmp", filepath.Base(path)))
+	err := p.fileIO.WriteFile(tempFile, contents, os.FileMode(0600))
+	if err != nil {
+		return err
+	}
+
+	err = p.fileIO.Rename(tempFile, path)
+	if err != nil {
+		return err
+	}
+
+	p.logger.Println(fmt.Sprintf("environment written to %s", path))
+
+	return nil
+}
+
+func renderEnvVars(shell string, envVars []envVar) ([]string, error) {
+	switch shell {
+	case "bash", "zsh", "":
+		lines := make([]string, len(envVars))
+		for i, v := range envVars {
+			lines[i] = fmt.Sprintf("export %s=%s", v.Key, quote(v))
+		}
+		return lines, nil
+	case "fish":
+		lines := make([]string, len(envVars))
+		for i, v := range envVars {
+			lines[i] = fmt.Sprintf("set -x %s %s", v.Key, quote(v))
+		}
+		return lines, nil
+	case "powershell":
+		// BOSH_ALL_PROXY ends with a $env:JUMPBOX_PRIVATE_KEY reference, which
+		// is left unescaped so PowerShell expands it; the rest is escaped.
+		ref := varRef(shell, "JUMPBOX_PRIVATE_KEY")
+		lines := make([]string, len(envVars))
+		for i, v := range envVars {
+			value := escapePowerShell(v.Value)
+			if v.Key == "BOSH_ALL_PROXY" && strings.HasSuffix(v.Value, ref) {
+				value = escapePowerShell(strings.TrimSuffix(v.Value, ref)) + ref
+			}
+			lines[i] = fmt.Sprintf(`$env:%s = "%s"`, v.Key, value)
+		}
+		return lines, nil
+	case "cmd":
+		lines := make([]string, len(envVars))
+		for i, v := range envVars {
+			if strings.Contains(v.Value, "\n") {
+				return nil, fmt.Errorf("%s is a multi-line value, which cmd.exe's \"set\" cannot represent; use --shell bash, fish, powershell, or json instead", v.Key)
+			}
+			lines[i] = fmt.Sprintf("set %s=%s", v.Key, v.Value)
+		}
+		return lines, nil
+	case "json":
+		values := make(map[string]string, len(envVars))
+		for _, v := range envVars {
+			values[v.Key] = v.Value
+		}
+
+		out, err := json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		return []string{string(out)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported --shell %q", shell)
+	}
+}
+
+// escapePowerShell escapes the characters that would otherwise corrupt or be
+// substituted within a double-quoted PowerShell string: the backtick, which
+// is PowerShell's own escape character, is escaped too (strings.Replacer makes
+// a single pass, so the backticks it inserts are not escaped again); the
+// double quote would otherwise
+// terminate the string early; and the dollar sign would otherwise trigger
+// variable interpolation, silently substituting the wrong value.
+func escapePowerShell(value string) string {
+	replacer := strings.NewReplacer(
+		"`", "``",
+		`"`, "`\"",
+		"$", "`$",
+	)
+	return replacer.Replace(value)
+}
+
+func quote(v envVar) string {
+	if !v.Quoted {
+		return v.Value
+	}
+
+	return "'" + v.Value + "'"
+}
+
+func varRef(shell, name string) string {
+	switch shell {
+	case "powershell":
+		return fmt.Sprintf("$env:%s", name)
+	case "cmd":
+		return fmt.Sprintf("%%%s%%", name)
+	default:
+		return "$" + name
+	}
+}
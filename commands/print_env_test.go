@@ -1,6 +1,7 @@
 package commands_test
 
 import (
+	"encoding/json"
 	"errors"
 	"path/filepath"
 	"strings"
@@ -108,6 +109,122 @@ var _ = Describe("PrintEnv", func() {
 			}
 		})
 
+		Context("when --shell fish is passed", func() {
+			It("renders fish-compatible set -x lines", func() {
+				err := printEnv.Execute([]string{"--shell", "fish"}, state)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(logger.PrintlnCall.Messages).To(ContainElement("set -x BOSH_CLIENT some-director-username"))
+				Expect(logger.PrintlnCall.Messages).To(ContainElement("set -x BOSH_CA_CERT 'some-director-ca-cert'"))
+				Expect(logger.PrintlnCall.Messages).To(ContainElement(MatchRegexp(`set -x BOSH_ALL_PROXY ssh\+socks5:\/\/jumpbox@some-magical-jumpbox-url:22\?private-key=\$JUMPBOX_PRIVATE_KEY`)))
+			})
+		})
+
+		Context("when --shell powershell is passed", func() {
+			It("renders PowerShell-compatible $env: assignments", func() {
+				err := printEnv.Execute([]string{"--shell", "powershell"}, state)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(logger.PrintlnCall.Messages).To(ContainElement(`$env:BOSH_CLIENT = "some-director-username"`))
+				Expect(logger.PrintlnCall.Messages).To(ContainElement(MatchRegexp(`\$env:BOSH_ALL_PROXY = "ssh\+socks5:\/\/jumpbox@some-magical-jumpbox-url:22\?private-key=\$env:JUMPBOX_PRIVATE_KEY"`)))
+			})
+
+			Context("when a value contains backtick, double-quote, or dollar-sign characters", func() {
+				BeforeEach(func() {
+					state.BOSH.DirectorPassword = "som`e\"pass$word"
+				})
+
+				It("escapes them so the value round-trips instead of corrupting or interpolating the script", func() {
+					err := printEnv.Execute([]string{"--shell", "powershell"}, state)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(logger.PrintlnCall.Messages).To(ContainElement("$env:BOSH_CLIENT_SECRET = \"som``e`\"pass`$word\""))
+				})
+			})
+		})
+
+		Context("when --shell cmd is passed", func() {
+			It("renders cmd-compatible set assignments", func() {
+				err := printEnv.Execute([]string{"--shell", "cmd"}, state)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(logger.PrintlnCall.Messages).To(ContainElement("set BOSH_CLIENT=some-director-username"))
+				Expect(logger.PrintlnCall.Messages).To(ContainElement(MatchRegexp(`set BOSH_ALL_PROXY=ssh\+socks5:\/\/jumpbox@some-magical-jumpbox-url:22\?private-key=%JUMPBOX_PRIVATE_KEY%`)))
+			})
+
+			Context("when a value spans multiple lines", func() {
+				BeforeEach(func() {
+					state.BOSH.DirectorSSLCA = "-----BEGIN CERTIFICATE-----\nsome-cert-body\n-----END CERTIFICATE-----"
+				})
+
+				It("returns an error instead of emitting a corrupt script", func() {
+					err := printEnv.Execute([]string{"--shell", "cmd"}, state)
+					Expect(err).To(MatchError(ContainSubstring("BOSH_CA_CERT is a multi-line value")))
+				})
+			})
+		})
+
+		Context("when --shell json is passed", func() {
+			It("prints a single JSON object with all of the variables", func() {
+				err := printEnv.Execute([]string{"--shell", "json"}, state)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(logger.PrintlnCall.Messages).To(HaveLen(1))
+
+				var env map[string]string
+				err = json.Unmarshal([]byte(logger.PrintlnCall.Messages[0]), &env)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(env["BOSH_CLIENT"]).To(Equal("some-director-username"))
+				Expect(env["BOSH_CA_CERT"]).To(Equal("some-director-ca-cert"))
+				Expect(env["BOSH_ALL_PROXY"]).To(ContainSubstring("private-key=" + filepath.Join("some-temp-dir", "bosh_jumpbox_private.key")))
+			})
+		})
+
+		Context("when --dump-file is passed", func() {
+			It("writes the rendered environment to the given path instead of stdout", func() {
+				err := printEnv.Execute([]string{"--dump-file", "/some/path/env.sh"}, state)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fileIO.WriteFileCall.Receives[1].Filename).To(Equal("/some/path/.env.sh.tmp"))
+				Expect(fileIO.WriteFileCall.Receives[1].Contents).To(ContainSubstring("export BOSH_CLIENT=some-director-username"))
+
+				Expect(fileIO.RenameCall.Receives.Oldpath).To(Equal("/some/path/.env.sh.tmp"))
+				Expect(fileIO.RenameCall.Receives.Newpath).To(Equal("/some/path/env.sh"))
+
+				Expect(logger.PrintlnCall.Messages).To(ContainElement("environment written to /some/path/env.sh"))
+				Expect(logger.PrintlnCall.Messages).NotTo(ContainElement(ContainSubstring("export BOSH_CLIENT")))
+			})
+
+			It("writes the jumpbox private key alongside the dump file instead of to a throwaway temp dir", func() {
+				err := printEnv.Execute([]string{"--dump-file", "/some/path/env.sh"}, state)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fileIO.WriteFileCall.Receives[0].Filename).To(Equal(filepath.Join("/some/path", "bosh_jumpbox_private.key")))
+				Expect(fileIO.WriteFileCall.Receives[0].Contents).To(Equal([]byte("some-private-key")))
+
+				Expect(fileIO.WriteFileCall.Receives[1].Contents).To(ContainSubstring(
+					"export JUMPBOX_PRIVATE_KEY=" + filepath.Join("/some/path", "bosh_jumpbox_private.key"),
+				))
+
+				Expect(fileIO.TempDirCall.CallCount).To(Equal(0))
+			})
+
+			Context("when writing the dump file fails", func() {
+				BeforeEach(func() {
+					fileIO.WriteFileCall.Returns = []fakes.WriteFileReturn{
+						{},
+						{Error: errors.New("papaya")},
+					}
+				})
+
+				It("returns an error", func() {
+					err := printEnv.Execute([]string{"--dump-file", "/some/path/env.sh"}, state)
+					Expect(err).To(MatchError("papaya"))
+				})
+			})
+		})
+
 		Context("when there is no director", func() {
 			BeforeEach(func() {
 				terraformManager.GetOutputsCall.Returns.Outputs = terraform.Outputs{
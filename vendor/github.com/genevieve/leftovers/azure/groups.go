@@ -1,15 +1,32 @@
+// This file carries local patches (regex/tag filtering, MinAge,
+// ConfirmAll/DeleteAll) on top of upstream genevieve/leftovers, tracked via
+// the Gopkg.toml override on this import path until they land upstream and
+// bbl can go back to vendoring genevieve/leftovers directly. Do not hand-edit
+// this file without updating that override's branch to match.
+
 package azure
 
 import (
+	"context"
 	"fmt"
+	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
 	"github.com/Azure/go-autorest/autorest"
 )
 
+// createdTimeTagKey is the tag leftovers looks for when deciding whether a
+// resource group is old enough to delete. bbl's own terraform tagging, and
+// several other provisioners, stamp this on creation.
+const createdTimeTagKey = "createdTime"
+
 type groupsClient interface {
-	List(query string, top *int32) (resources.GroupListResult, error)
+	List(filter string, top *int32) (resources.GroupListResult, error)
+	Get(name string) (resources.Group, error)
 	Delete(name string, channel <-chan struct{}) (<-chan autorest.Response, <-chan error)
 }
 
@@ -25,8 +42,41 @@ func NewGroups(client groupsClient, logger logger) Groups {
 	}
 }
 
-func (g Groups) List(filter string) ([]Deletable, error) {
-	groups, err := g.client.List("", nil)
+// Tag is a single tagName/tagValue pair used to narrow down a List call via
+// the Azure OData $filter query, e.g. --tag env=ci.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// GroupsFilter controls which resource groups List considers. The Resource
+// Groups List API's $filter only supports a single tagName/tagValue pair, so
+// the first of Tags is compiled into an OData $filter and handed to the API
+// server-side; any further tags are matched client-side against the tags on
+// each returned group. Regex is matched against the group name client-side;
+// when it isn't set, List falls back to the previous substring behavior
+// against Contains.
+type GroupsFilter struct {
+	Contains string
+	Regex    string
+	Tags     []Tag
+
+	// MinAge, when non-zero, skips resource groups whose createdTime tag is
+	// newer than now - MinAge.
+	MinAge time.Duration
+
+	// ConfirmAll, when true, replaces the per-group deletion prompt with a
+	// single prompt for the whole matching batch.
+	ConfirmAll bool
+}
+
+func (g Groups) List(filter GroupsFilter) ([]Deletable, error) {
+	nameMatches, err := nameMatcher(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := g.client.List(tagsODataFilter(filter.Tags), nil)
 	if err != nil {
 		return nil, fmt.Errorf("Listing Resource Groups: %s", err)
 	}
@@ -35,7 +85,27 @@ func (g Groups) List(filter string) ([]Deletable, error) {
 	for _, group := range *groups.Value {
 		r := NewGroup(g.client, group.Name)
 
-		if !strings.Contains(r.Name(), filter) {
+		if !nameMatches(r.Name()) {
+			continue
+		}
+
+		if !tagsMatch(group, filter.Tags) {
+			continue
+		}
+
+		if filter.MinAge > 0 {
+			old, err := g.olderThan(r.Name(), filter.MinAge)
+			if err != nil {
+				return nil, fmt.Errorf("Checking age of %q: %s", r.Name(), err)
+			}
+
+			if !old {
+				continue
+			}
+		}
+
+		if filter.ConfirmAll {
+			resources = append(resources, r)
 			continue
 		}
 
@@ -47,5 +117,240 @@ func (g Groups) List(filter string) ([]Deletable, error) {
 		resources = append(resources, r)
 	}
 
+	if filter.ConfirmAll && len(resources) > 0 {
+		proceed := g.logger.PromptWithDetails("Resource Group", fmt.Sprintf("%d matching resource group(s)", len(resources)))
+		if !proceed {
+			return nil, nil
+		}
+	}
+
 	return resources, nil
 }
+
+// Delete lists groups matching filter, confirms them, and deletes the
+// confirmed batch, fanning the deletes out across parallelism workers. This
+// is the single entry point a leftovers-delete command should call: its
+// `--yes` flag should set filter.ConfirmAll and its `--parallelism` flag
+// should pass through unchanged, the same way commands.PrintEnv binds its own
+// flags via the flags package at the start of its Execute. No such
+// command exists yet in this tree; wiring one up is tracked separately from
+// this library change.
+func (g Groups) Delete(ctx context.Context, filter GroupsFilter, parallelism int) error {
+	list, err := g.List(filter)
+	if err != nil {
+		return err
+	}
+
+	return g.DeleteAll(ctx, list, parallelism)
+}
+
+// DeleteAll deletes every resource in list, fanning the work out across
+// parallelism worker goroutines once the caller has approved the batch (see
+// GroupsFilter.ConfirmAll). Ordering across workers is not guaranteed.
+// Errors from individual deletions are collected into a single multiError
+// rather than aborting the remaining work, and ctx is honored so that a
+// Ctrl-C stops outstanding deletes instead of leaking goroutines.
+// parallelism < 1 is treated as 1, preserving today's serial behavior.
+func (g Groups) DeleteAll(ctx context.Context, list []Deletable, parallelism int) error {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	jobs := make(chan Deletable)
+	errs := make(chan error, len(list))
+
+	var skipped int32
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for r := range jobs {
+				if ctx.Err() != nil {
+					atomic.AddInt32(&skipped, 1)
+					continue
+				}
+
+				if err := g.delete(ctx, r); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+
+		for _, r := range list {
+			if ctx.Err() != nil {
+				atomic.AddInt32(&skipped, 1)
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				atomic.AddInt32(&skipped, 1)
+			case jobs <- r:
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+
+	var result error
+	for err := range errs {
+		result = appendError(result, err)
+	}
+
+	// Only the items that were never passed to client.Delete, because the
+	// run was canceled before a worker got to them, are unaccounted for here;
+	// in-flight deletes that were canceled already reported their own
+	// "context canceled" error above, so this is worded to add the skipped
+	// count rather than repeat that same cancellation message a second time.
+	if skipped > 0 {
+		result = appendError(result, fmt.Errorf("Deleting resource groups: %d skipped because the run was canceled", skipped))
+	}
+
+	return result
+}
+
+func (g Groups) delete(ctx context.Context, r Deletable) error {
+	cancel := make(chan struct{})
+	respCh, errCh := g.client.Delete(r.Name(), cancel)
+
+	select {
+	case <-ctx.Done():
+		close(cancel)
+		return fmt.Errorf("Deleting %s %q: %s", r.Type(), r.Name(), ctx.Err())
+	case err := <-errCh:
+		if err != nil {
+			return fmt.Errorf("Deleting %s %q: %s", r.Type(), r.Name(), err)
+		}
+		return nil
+	case <-respCh:
+		return nil
+	}
+}
+
+// multiError aggregates the independent failures from a DeleteAll run into a
+// single error, since any number of the parallel deletes may fail.
+type multiError struct {
+	errs []error
+}
+
+func (m *multiError) Error() string {
+	messages := make([]string, len(m.errs))
+	for i, err := range m.errs {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+func appendError(err error, next error) error {
+	if next == nil {
+		return err
+	}
+
+	m, ok := err.(*multiError)
+	if !ok {
+		m = &multiError{}
+		if err != nil {
+			m.errs = append(m.errs, err)
+		}
+	}
+
+	m.errs = append(m.errs, next)
+	return m
+}
+
+// olderThan fetches the group's metadata and compares its createdTime tag
+// against minAge. A group with no createdTime tag, or one that doesn't parse
+// as RFC3339, can't be judged on age and is treated as old enough to avoid
+// silently hiding untagged leftovers from the sweep.
+func (g Groups) olderThan(name string, minAge time.Duration) (bool, error) {
+	group, err := g.client.Get(name)
+	if err != nil {
+		return false, fmt.Errorf("Getting Resource Group: %s", err)
+	}
+
+	createdTime, ok := createdTime(group)
+	if !ok {
+		return true, nil
+	}
+
+	return createdTime.Before(time.Now().Add(-minAge)), nil
+}
+
+func createdTime(group resources.Group) (time.Time, bool) {
+	if group.Tags == nil {
+		return time.Time{}, false
+	}
+
+	value, ok := (*group.Tags)[createdTimeTagKey]
+	if !ok || value == nil {
+		return time.Time{}, false
+	}
+
+	parsed, err := time.Parse(time.RFC3339, *value)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return parsed, true
+}
+
+func nameMatcher(filter GroupsFilter) (func(string) bool, error) {
+	if filter.Regex == "" {
+		return func(name string) bool {
+			return strings.Contains(name, filter.Contains)
+		}, nil
+	}
+
+	re, err := regexp.Compile(filter.Regex)
+	if err != nil {
+		return nil, fmt.Errorf("Compiling regex %q: %s", filter.Regex, err)
+	}
+
+	return re.MatchString, nil
+}
+
+// tagsODataFilter compiles the first tag selector into the OData $filter
+// syntax understood by the Resource Groups List API, e.g.
+// "tagName eq 'env' and tagValue eq 'ci'". The API only supports filtering on
+// one tag per request; any additional selectors are matched client-side by
+// tagsMatch. An empty slice returns "", which lists every group as before.
+func tagsODataFilter(tags []Tag) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	first := tags[0]
+	return fmt.Sprintf("tagName eq '%s' and tagValue eq '%s'", first.Key, first.Value)
+}
+
+// tagsMatch checks the tag selectors beyond the first (which already went
+// server-side via tagsODataFilter) against the tags on group.
+func tagsMatch(group resources.Group, tags []Tag) bool {
+	if len(tags) <= 1 {
+		return true
+	}
+
+	for _, tag := range tags[1:] {
+		if group.Tags == nil {
+			return false
+		}
+
+		value, ok := (*group.Tags)[tag.Key]
+		if !ok || value == nil || *value != tag.Value {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,413 @@
+package azure_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/arm/resources/resources"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/genevieve/leftovers/azure"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type fakeGroupsClient struct {
+	listCall struct {
+		receives struct {
+			Filter string
+		}
+		returns struct {
+			Result resources.GroupListResult
+			Error  error
+		}
+	}
+
+	getCall struct {
+		receives struct {
+			Names []string
+		}
+		returns map[string]resources.Group
+		errors  map[string]error
+	}
+
+	deleteCall struct {
+		mutex    sync.Mutex
+		receives []string
+		errors   map[string]error
+	}
+}
+
+func (f *fakeGroupsClient) List(filter string, top *int32) (resources.GroupListResult, error) {
+	f.listCall.receives.Filter = filter
+	return f.listCall.returns.Result, f.listCall.returns.Error
+}
+
+func (f *fakeGroupsClient) Get(name string) (resources.Group, error) {
+	f.getCall.receives.Names = append(f.getCall.receives.Names, name)
+
+	if err, ok := f.getCall.errors[name]; ok {
+		return resources.Group{}, err
+	}
+
+	return f.getCall.returns[name], nil
+}
+
+func (f *fakeGroupsClient) Delete(name string, channel <-chan struct{}) (<-chan autorest.Response, <-chan error) {
+	f.deleteCall.mutex.Lock()
+	f.deleteCall.receives = append(f.deleteCall.receives, name)
+	f.deleteCall.mutex.Unlock()
+
+	respCh := make(chan autorest.Response, 1)
+	errCh := make(chan error, 1)
+
+	if err, ok := f.deleteCall.errors[name]; ok && err != nil {
+		errCh <- err
+	} else {
+		respCh <- autorest.Response{}
+	}
+
+	return respCh, errCh
+}
+
+// blockingDeleteClient mirrors fakeGroupsClient's List/Get behavior for three
+// groups, but its Delete only resolves once unblock is closed, so a test can
+// cancel a DeleteAll's context while a delete is genuinely in flight. started
+// is closed on the first Delete call, letting the test wait for that before
+// canceling.
+type blockingDeleteClient struct {
+	unblock    chan struct{}
+	started    chan struct{}
+	startedOne sync.Once
+}
+
+func (b *blockingDeleteClient) List(filter string, top *int32) (resources.GroupListResult, error) {
+	return resources.GroupListResult{
+		Value: &[]resources.Group{
+			{Name: strPointer("banana-group")},
+			{Name: strPointer("kiwi-group")},
+			{Name: strPointer("other-group")},
+		},
+	}, nil
+}
+
+func (b *blockingDeleteClient) Get(name string) (resources.Group, error) {
+	return resources.Group{}, nil
+}
+
+func (b *blockingDeleteClient) Delete(name string, channel <-chan struct{}) (<-chan autorest.Response, <-chan error) {
+	b.startedOne.Do(func() { close(b.started) })
+
+	respCh := make(chan autorest.Response, 1)
+	errCh := make(chan error, 1)
+
+	go func() {
+		<-b.unblock
+		respCh <- autorest.Response{}
+	}()
+
+	return respCh, errCh
+}
+
+type fakeLogger struct {
+	promptWithDetailsCall struct {
+		receives []string
+		returns  bool
+	}
+}
+
+func (f *fakeLogger) PromptWithDetails(action, name string) bool {
+	f.promptWithDetailsCall.receives = append(f.promptWithDetailsCall.receives, name)
+	return f.promptWithDetailsCall.returns
+}
+
+func strPointer(s string) *string {
+	return &s
+}
+
+var _ = Describe("Groups", func() {
+	var (
+		client *fakeGroupsClient
+		logger *fakeLogger
+		groups azure.Groups
+	)
+
+	BeforeEach(func() {
+		client = &fakeGroupsClient{}
+		logger = &fakeLogger{}
+		logger.promptWithDetailsCall.returns = true
+
+		client.listCall.returns.Result = resources.GroupListResult{
+			Value: &[]resources.Group{
+				{Name: strPointer("banana-group")},
+				{Name: strPointer("kiwi-group")},
+				{Name: strPointer("other-group")},
+			},
+		}
+
+		groups = azure.NewGroups(client, logger)
+	})
+
+	Describe("List", func() {
+		Context("when tags are provided", func() {
+			It("compiles the first tag into the OData $filter query sent to the client", func() {
+				_, err := groups.List(azure.GroupsFilter{
+					Tags: []azure.Tag{
+						{Key: "env", Value: "ci"},
+						{Key: "owner", Value: "toolsmiths"},
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(client.listCall.receives.Filter).To(Equal("tagName eq 'env' and tagValue eq 'ci'"))
+			})
+
+			Context("when there is more than one tag", func() {
+				BeforeEach(func() {
+					client.listCall.returns.Result = resources.GroupListResult{
+						Value: &[]resources.Group{
+							{Name: strPointer("banana-group"), Tags: &map[string]*string{"owner": strPointer("toolsmiths")}},
+							{Name: strPointer("kiwi-group"), Tags: &map[string]*string{"owner": strPointer("someone-else")}},
+						},
+					}
+				})
+
+				It("matches the remaining tags client-side, since the API only filters on one", func() {
+					resources, err := groups.List(azure.GroupsFilter{
+						Tags: []azure.Tag{
+							{Key: "env", Value: "ci"},
+							{Key: "owner", Value: "toolsmiths"},
+						},
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(resources).To(HaveLen(1))
+					Expect(logger.promptWithDetailsCall.receives).To(ConsistOf("banana-group"))
+				})
+			})
+		})
+
+		Context("when no tags are provided", func() {
+			It("sends an empty filter", func() {
+				_, err := groups.List(azure.GroupsFilter{Contains: "banana"})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(client.listCall.receives.Filter).To(Equal(""))
+			})
+		})
+
+		Context("when a regex is provided", func() {
+			It("matches group names against it instead of Contains", func() {
+				resources, err := groups.List(azure.GroupsFilter{Regex: "^(banana|kiwi)-group$"})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(resources).To(HaveLen(2))
+				Expect(logger.promptWithDetailsCall.receives).To(ConsistOf("banana-group", "kiwi-group"))
+			})
+
+			Context("when the regex fails to compile", func() {
+				It("returns an error", func() {
+					_, err := groups.List(azure.GroupsFilter{Regex: "("})
+					Expect(err).To(MatchError(ContainSubstring("Compiling regex")))
+				})
+			})
+		})
+
+		Context("when no regex is provided", func() {
+			It("falls back to the existing substring match on Contains", func() {
+				resources, err := groups.List(azure.GroupsFilter{Contains: "banana"})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(resources).To(HaveLen(1))
+				Expect(logger.promptWithDetailsCall.receives).To(ConsistOf("banana-group"))
+			})
+		})
+
+		Context("when the client fails to list groups", func() {
+			BeforeEach(func() {
+				client.listCall.returns.Error = errors.New("some list error")
+			})
+
+			It("returns an error", func() {
+				_, err := groups.List(azure.GroupsFilter{})
+				Expect(err).To(MatchError("Listing Resource Groups: some list error"))
+			})
+		})
+
+		Context("when MinAge is provided", func() {
+			BeforeEach(func() {
+				client.getCall.returns = map[string]resources.Group{
+					"banana-group": {Tags: &map[string]*string{
+						"createdTime": strPointer(time.Now().Add(-48 * time.Hour).Format(time.RFC3339)),
+					}},
+					"kiwi-group": {Tags: &map[string]*string{
+						"createdTime": strPointer(time.Now().Format(time.RFC3339)),
+					}},
+				}
+			})
+
+			It("skips groups that are newer than now - MinAge", func() {
+				resources, err := groups.List(azure.GroupsFilter{MinAge: 24 * time.Hour})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(resources).To(HaveLen(2))
+				Expect(logger.promptWithDetailsCall.receives).To(ConsistOf("banana-group", "other-group"))
+			})
+
+			Context("when a group has no createdTime tag", func() {
+				It("includes it, since its age can't be determined", func() {
+					_, err := groups.List(azure.GroupsFilter{Contains: "other", MinAge: 24 * time.Hour})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(logger.promptWithDetailsCall.receives).To(ConsistOf("other-group"))
+				})
+			})
+
+			Context("when fetching the group's metadata fails", func() {
+				BeforeEach(func() {
+					client.getCall.errors = map[string]error{
+						"banana-group": errors.New("some get error"),
+					}
+				})
+
+				It("returns an error", func() {
+					_, err := groups.List(azure.GroupsFilter{Contains: "banana", MinAge: 24 * time.Hour})
+					Expect(err).To(MatchError(ContainSubstring("some get error")))
+				})
+			})
+		})
+
+		Context("when ConfirmAll is set", func() {
+			It("prompts once for the whole batch instead of once per group", func() {
+				resources, err := groups.List(azure.GroupsFilter{ConfirmAll: true})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(resources).To(HaveLen(3))
+				Expect(logger.promptWithDetailsCall.receives).To(Equal([]string{"3 matching resource group(s)"}))
+			})
+
+			Context("when the batch is declined", func() {
+				BeforeEach(func() {
+					logger.promptWithDetailsCall.returns = false
+				})
+
+				It("returns no resources", func() {
+					resources, err := groups.List(azure.GroupsFilter{ConfirmAll: true})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(resources).To(BeEmpty())
+				})
+			})
+		})
+	})
+
+	Describe("Delete", func() {
+		It("lists, confirms, and deletes the matching batch in one call", func() {
+			err := groups.Delete(context.Background(), azure.GroupsFilter{ConfirmAll: true}, 3)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(client.deleteCall.receives).To(ConsistOf("banana-group", "kiwi-group", "other-group"))
+		})
+
+		Context("when listing fails", func() {
+			BeforeEach(func() {
+				client.listCall.returns.Error = errors.New("some list error")
+			})
+
+			It("returns the error without attempting to delete anything", func() {
+				err := groups.Delete(context.Background(), azure.GroupsFilter{ConfirmAll: true}, 3)
+				Expect(err).To(MatchError(ContainSubstring("some list error")))
+
+				Expect(client.deleteCall.receives).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("DeleteAll", func() {
+		var list []azure.Deletable
+
+		BeforeEach(func() {
+			list, _ = groups.List(azure.GroupsFilter{ConfirmAll: true})
+			Expect(list).To(HaveLen(3))
+		})
+
+		It("deletes every resource, fanning the work out across parallelism workers", func() {
+			err := groups.DeleteAll(context.Background(), list, 3)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(client.deleteCall.receives).To(ConsistOf("banana-group", "kiwi-group", "other-group"))
+		})
+
+		Context("when parallelism is 1", func() {
+			It("preserves today's serial behavior exactly", func() {
+				err := groups.DeleteAll(context.Background(), list, 1)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(client.deleteCall.receives).To(Equal([]string{"banana-group", "kiwi-group", "other-group"}))
+			})
+		})
+
+		Context("when some deletions fail", func() {
+			BeforeEach(func() {
+				client.deleteCall.errors = map[string]error{
+					"kiwi-group": errors.New("some delete error"),
+				}
+			})
+
+			It("surfaces the failure without aborting the rest", func() {
+				err := groups.DeleteAll(context.Background(), list, 3)
+				Expect(err).To(MatchError(ContainSubstring("some delete error")))
+
+				Expect(client.deleteCall.receives).To(ConsistOf("banana-group", "kiwi-group", "other-group"))
+			})
+		})
+
+		Context("when the context is canceled before any work is handed out", func() {
+			It("stops handing out further work and reports the skipped items once", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				err := groups.DeleteAll(ctx, list, 1)
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("3 skipped"))
+
+				Expect(client.deleteCall.receives).To(BeEmpty())
+			})
+		})
+
+		Context("when the context is canceled mid-delete", func() {
+			It("doesn't pile a redundant cancellation error on top of the in-flight one", func() {
+				blockingClient := &blockingDeleteClient{
+					unblock: make(chan struct{}),
+					started: make(chan struct{}),
+				}
+				blockingGroups := azure.NewGroups(blockingClient, logger)
+				blockingList, _ := blockingGroups.List(azure.GroupsFilter{ConfirmAll: true})
+				Expect(blockingList).To(HaveLen(3))
+
+				ctx, cancel := context.WithCancel(context.Background())
+
+				done := make(chan error, 1)
+				go func() {
+					done <- blockingGroups.DeleteAll(ctx, blockingList, 1)
+				}()
+
+				// Wait for the first delete to actually be in flight before
+				// canceling, so this exercises the in-flight path in delete()
+				// rather than racing the producer's before-dispatch skip. The
+				// fake's Delete never resolves on its own, so delete() is
+				// guaranteed to observe ctx.Done() rather than racing it
+				// against a response.
+				<-blockingClient.started
+				cancel()
+
+				err := <-done
+				close(blockingClient.unblock)
+
+				Expect(err).To(HaveOccurred())
+				Expect(strings.Count(err.Error(), "context canceled")).To(Equal(1))
+			})
+		})
+	})
+})